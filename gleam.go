@@ -2,79 +2,208 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redis/rueidis"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/sync/singleflight"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"encoding/base64"
-	"encoding/binary"
+	"encoding/json"
 )
 
 var ctx = context.Background()
 
 type Cache interface {
-	Set(key string, content []byte, header http.Header, ttl time.Duration)
+	// Set stores content in the cache for ttl, after which it is no longer
+	// fresh. If staleWhileRevalidate is non-zero, the entry remains servable
+	// as stale for that long past ttl while a refresh is attempted.
+	Set(key string, content []byte, header http.Header, status int, ttl time.Duration, staleWhileRevalidate time.Duration)
 	Get(key string) (*CacheItem, bool)
+	// Purge removes every cached entry whose key matches pattern. An exact
+	// key with no glob metacharacters purges just that one entry; "*" and
+	// "?" are glob wildcards. SimpleCache matches with path.Match, where
+	// neither wildcard matches a '/'; the Redis-backed caches match with
+	// SCAN MATCH, where both do.
+	Purge(pattern string)
 }
 
+// defaultCleanupInterval is how often the periodic expiration sweeper runs
+// when a caller doesn't specify its own interval.
+const defaultCleanupInterval = 1 * time.Minute
+
+// PreExpirationFn is invoked by the periodic cleanup sweep for each entry
+// about to be evicted. Returning a non-nil content re-inserts the entry
+// with the given ttl instead of evicting it, enabling cache warming /
+// refresh-ahead; returning a nil content lets the entry be evicted as
+// normal.
+type PreExpirationFn func(ctx context.Context, key string) (content []byte, header http.Header, ttl time.Duration)
+
 // SimpleCache holds the cache data
 type SimpleCache struct {
-	mu    sync.Mutex
-	store map[string]*CacheItem
+	mu              sync.Mutex
+	store           map[string]*CacheItem
+	preExpirationFn PreExpirationFn
 }
 
 // CacheItem represents a single cache entry
 type CacheItem struct {
 	content    []byte
 	header     http.Header
-	expiration time.Time
+	status     int
+	storedAt   time.Time
+	expiration time.Time // end of freshness; after this the entry is stale
+	staleUntil time.Time // end of the stale-while-revalidate window
+}
+
+// fresh reports whether the item is still within its TTL.
+func (i *CacheItem) fresh() bool {
+	return i.expiration.After(time.Now())
 }
 
 // Set stores data in the cache
-func (c *SimpleCache) Set(key string, content []byte, header http.Header, ttl time.Duration) {
+func (c *SimpleCache) Set(key string, content []byte, header http.Header, status int, ttl time.Duration, staleWhileRevalidate time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := time.Now()
 	c.store[key] = &CacheItem{
 		content:    content,
 		header:     header,
-		expiration: time.Now().Add(ttl),
+		status:     status,
+		storedAt:   now,
+		expiration: now.Add(ttl),
+		staleUntil: now.Add(ttl + staleWhileRevalidate),
 	}
 }
 
-// Get retrieves data from the cache
+// Get retrieves data from the cache. An entry is returned as long as it is
+// within its stale-while-revalidate window; callers should check
+// CacheItem.fresh() to distinguish a HIT from a STALE.
 func (c *SimpleCache) Get(key string) (*CacheItem, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	item, found := c.store[key]
-	if !found || item.expiration.Before(time.Now()) {
+	if !found || item.staleUntil.Before(time.Now()) {
 		return nil, false
 	}
 	return item, true
 }
 
-// NewSimpleCache initializes and returns a new SimpleCache
+// Purge removes every entry whose key matches pattern.
+func (c *SimpleCache) Purge(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.store {
+		if ok, _ := path.Match(pattern, key); ok {
+			delete(c.store, key)
+		}
+	}
+}
+
+// NewSimpleCache initializes and returns a new SimpleCache, with a
+// periodic sweeper evicting expired entries on defaultCleanupInterval.
 func NewSimpleCache() *SimpleCache {
-	return &SimpleCache{
-		store: make(map[string]*CacheItem),
+	return NewSimpleCacheWithContext(context.Background(), defaultCleanupInterval, nil)
+}
+
+// NewSimpleCacheWithContext initializes a new SimpleCache whose periodic
+// expiration sweeper runs on cleanupInterval and stops when ctx is done. If
+// preExpirationFn is non-nil, it is given a chance to refresh each entry
+// before it is evicted.
+func NewSimpleCacheWithContext(ctx context.Context, cleanupInterval time.Duration, preExpirationFn PreExpirationFn) *SimpleCache {
+	c := &SimpleCache{
+		store:           make(map[string]*CacheItem),
+		preExpirationFn: preExpirationFn,
+	}
+	go c.periodicCleanup(ctx, cleanupInterval)
+	return c
+}
+
+// periodicCleanup runs sweep on cleanupInterval until ctx is done.
+func (c *SimpleCache) periodicCleanup(ctx context.Context, cleanupInterval time.Duration) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.sweep(ctx)
+		}
+	}
+}
+
+// sweep evicts expired entries in two passes to minimize lock hold time:
+// it first collects the expired keys under the mutex, then, without
+// holding the lock, gives preExpirationFn a chance to refresh each one
+// before deleting it.
+func (c *SimpleCache) sweep(ctx context.Context) {
+	now := time.Now()
+
+	c.mu.Lock()
+	expired := make(map[string]int, 0) // key -> status, for a faithful refresh
+	for key, item := range c.store {
+		if item.staleUntil.Before(now) {
+			expired[key] = item.status
+		}
+	}
+	c.mu.Unlock()
+
+	for key, status := range expired {
+		if c.preExpirationFn != nil {
+			if content, header, ttl := c.preExpirationFn(ctx, key); content != nil {
+				c.Set(key, content, header, status, ttl, 0)
+				continue
+			}
+		}
+
+		c.mu.Lock()
+		if item, found := c.store[key]; found && item.staleUntil.Before(now) {
+			delete(c.store, key)
+		}
+		c.mu.Unlock()
 	}
 }
 
 // RedisCache implements the Cache interface using Redis
 type RedisCache struct {
-	client *redis.Client
+	client          *redis.Client
+	config          *Config
+	preExpirationFn PreExpirationFn
 }
 
 // NewRedisCache initializes and returns a new RedisCache using a single Redis URL
-func NewRedisCache(redisURL string) *RedisCache {
+func NewRedisCache(redisURL string, config *Config) *RedisCache {
+	return NewRedisCacheWithContext(context.Background(), redisURL, config, 0, nil)
+}
+
+// NewRedisCacheWithContext initializes a RedisCache using a single Redis
+// URL. config governs the wire codec (compression codec and threshold); if
+// preExpirationFn is non-nil, a ticker-driven sweep runs on sweepInterval
+// (defaulting to defaultCleanupInterval), scanning for keys nearing expiry
+// and giving the hook a chance to refresh them before Redis evicts them;
+// the sweep stops when ctx is done.
+func NewRedisCacheWithContext(ctx context.Context, redisURL string, config *Config, sweepInterval time.Duration, preExpirationFn PreExpirationFn) *RedisCache {
 	// Parse the Redis URL
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
@@ -83,20 +212,79 @@ func NewRedisCache(redisURL string) *RedisCache {
 
 	// Initialize the Redis client using the parsed options
 	rdb := redis.NewClient(opt)
-	return &RedisCache{
-		client: rdb,
+	r := &RedisCache{
+		client:          rdb,
+		config:          config,
+		preExpirationFn: preExpirationFn,
+	}
+
+	if preExpirationFn != nil {
+		if sweepInterval <= 0 {
+			sweepInterval = defaultCleanupInterval
+		}
+		go r.periodicCleanup(ctx, sweepInterval)
+	}
+
+	return r
+}
+
+// periodicCleanup runs sweep on interval until ctx is done.
+func (r *RedisCache) periodicCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.sweep(ctx, interval)
+		}
+	}
+}
+
+// sweep scans all keys for ones within threshold of expiring and offers
+// preExpirationFn a chance to refresh them before Redis evicts them. The
+// refreshed entry is stored under the existing entry's own status, for a
+// faithful refresh, matching SimpleCache.sweep.
+func (r *RedisCache) sweep(ctx context.Context, threshold time.Duration) {
+	iter := r.client.Scan(ctx, 0, "*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		ttl, err := r.client.TTL(ctx, key).Result()
+		if err != nil || ttl <= 0 || ttl > threshold {
+			continue
+		}
+
+		item, found := r.Get(key)
+		if !found {
+			continue
+		}
+
+		content, header, newTTL := r.preExpirationFn(ctx, key)
+		if content == nil {
+			continue
+		}
+		r.Set(key, content, header, item.status, newTTL, 0)
 	}
 }
 
 // Set stores data in Redis
-func (r *RedisCache) Set(key string, content []byte, header http.Header, ttl time.Duration) {
-	// Serialize CacheItem
+func (r *RedisCache) Set(key string, content []byte, header http.Header, status int, ttl time.Duration, staleWhileRevalidate time.Duration) {
+	now := time.Now()
 	cacheItem := CacheItem{
-		content: content,
-		header:  header,
+		content:    content,
+		header:     header,
+		status:     status,
+		storedAt:   now,
+		expiration: now.Add(ttl),
+		staleUntil: now.Add(ttl + staleWhileRevalidate),
 	}
-	itemBytes, _ := encodeCacheItem(cacheItem)
-	r.client.Set(ctx, key, itemBytes, ttl).Err()
+	itemBytes, _ := encodeCacheItem(cacheItem, r.config)
+	// Let the entry live in Redis through the end of its stale window; the
+	// handler decides HIT vs STALE from the embedded expiration.
+	r.client.Set(ctx, key, itemBytes, ttl+staleWhileRevalidate).Err()
 }
 
 // Get retrieves data from Redis
@@ -117,6 +305,109 @@ func (r *RedisCache) Get(key string) (*CacheItem, bool) {
 	return cacheItem, true
 }
 
+// Purge removes every key matching pattern via SCAN/DEL.
+func (r *RedisCache) Purge(pattern string) {
+	iter := r.client.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		r.client.Del(ctx, iter.Val())
+	}
+}
+
+// RueidisCache implements the Cache interface using rueidis's DoCache API,
+// which layers an in-process LRU on top of Redis with server-assisted
+// invalidation (RESP3 CLIENT TRACKING). Hot entries are served from local
+// memory with zero network round trips while remaining coherent.
+type RueidisCache struct {
+	client rueidis.Client
+	config *Config
+}
+
+// NewRueidisCache initializes and returns a new RueidisCache using a
+// single Redis URL. config.RueidisCacheSizeBytes bounds the client-side
+// LRU; config also governs the wire codec, same as RedisCache.
+func NewRueidisCache(redisURL string, config *Config) *RueidisCache {
+	opts, err := rueidis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("Failed to parse Redis URL: %v", err)
+	}
+	if config != nil && config.RueidisCacheSizeBytes > 0 {
+		opts.CacheSizeEachConn = config.RueidisCacheSizeBytes
+	}
+
+	client, err := rueidis.NewClient(opts)
+	if err != nil {
+		log.Fatalf("Failed to create rueidis client: %v", err)
+	}
+
+	return &RueidisCache{client: client, config: config}
+}
+
+// Set stores data in Redis
+func (r *RueidisCache) Set(key string, content []byte, header http.Header, status int, ttl time.Duration, staleWhileRevalidate time.Duration) {
+	now := time.Now()
+	cacheItem := CacheItem{
+		content:    content,
+		header:     header,
+		status:     status,
+		storedAt:   now,
+		expiration: now.Add(ttl),
+		staleUntil: now.Add(ttl + staleWhileRevalidate),
+	}
+	itemBytes, err := encodeCacheItem(cacheItem, r.config)
+	if err != nil {
+		return
+	}
+
+	cmd := r.client.B().Set().Key(key).Value(rueidis.BinaryString(itemBytes)).Ex(ttl + staleWhileRevalidate).Build()
+	r.client.Do(ctx, cmd)
+}
+
+// Get retrieves data via DoCache, serving from the local LRU when Redis
+// hasn't pushed an invalidation for key since it was last fetched.
+func (r *RueidisCache) Get(key string) (*CacheItem, bool) {
+	cmd := r.client.B().Get().Key(key).Cache()
+	result, err := r.client.DoCache(ctx, cmd, r.localCacheTTL()).ToString()
+	if err != nil {
+		return nil, false
+	}
+
+	cacheItem, err := decodeCacheItem([]byte(result))
+	if err != nil {
+		return nil, false
+	}
+
+	return cacheItem, true
+}
+
+// Purge removes every key matching pattern via SCAN/DEL. rueidis's client-
+// side tracking propagates the deletion to every instance's local LRU.
+func (r *RueidisCache) Purge(pattern string) {
+	cursor := uint64(0)
+	for {
+		entry, err := r.client.Do(ctx, r.client.B().Scan().Cursor(cursor).Match(pattern).Build()).AsScanEntry()
+		if err != nil {
+			return
+		}
+		for _, key := range entry.Elements {
+			r.client.Do(ctx, r.client.B().Del().Key(key).Build())
+		}
+		if entry.Cursor == 0 {
+			return
+		}
+		cursor = entry.Cursor
+	}
+}
+
+// localCacheTTL bounds how long rueidis may serve a key from its local LRU
+// before re-validating with Redis, approximated from config.TTL since the
+// entry's own remaining server TTL isn't cheaply available per Get.
+func (r *RueidisCache) localCacheTTL() time.Duration {
+	if r.config != nil && r.config.TTL > 0 {
+		return r.config.TTL
+	}
+	return defaultCleanupInterval
+}
+
 type CacheResponseWriter struct {
 	http.ResponseWriter
 	buf    *bytes.Buffer
@@ -138,13 +429,36 @@ func (w *CacheResponseWriter) Header() http.Header {
 	return w.ResponseWriter.Header()
 }
 
+// recordingWriter captures an origin response without writing it to any
+// client. It lets a single origin fetch be coalesced via singleflight and
+// then replayed to every waiting requester.
+type recordingWriter struct {
+	header http.Header
+	buf    bytes.Buffer
+	status int
+}
+
+func newRecordingWriter() *recordingWriter {
+	return &recordingWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *recordingWriter) Header() http.Header         { return w.header }
+func (w *recordingWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+func (w *recordingWriter) WriteHeader(status int)      { w.status = status }
+
 // Config holds all configurable options
 type Config struct {
-	OriginURL string
-	TTL       time.Duration
-	Port      string
-	RedisURL  string
-	CacheType string
+	OriginURL             string
+	TTL                   time.Duration
+	StaleWhileRevalidate  time.Duration
+	Port                  string
+	RedisURL              string
+	CacheBackend          string
+	RulesFile             string
+	CompressionCodec      string
+	CompressionThreshold  int
+	RueidisCacheSizeBytes int
+	PubSubInvalidation    bool
 }
 
 // loadConfig loads configuration from environment variables
@@ -154,21 +468,188 @@ func loadConfig() *Config {
 		log.Fatalf("Error parsing TTL_MINUTES: %v", err)
 	}
 
+	swrSeconds, err := strconv.Atoi(getenv("STALE_WHILE_REVALIDATE_SECONDS", "0"))
+	if err != nil {
+		log.Fatalf("Error parsing STALE_WHILE_REVALIDATE_SECONDS: %v", err)
+	}
+
 	redisUrl := getenv("REDIS_URL", "redis://localhost:6379/0")
-	cacheType := getenv("CACHE_TYPE", "memory")
-	if cacheType != "redis" && cacheType != "memory" {
-		log.Fatalf("Invalid CACHE_TYPE, must be 'memory' (default) or 'redis'")
+	cacheBackend := getenv("CACHE_BACKEND", "memory")
+	if cacheBackend != "redis" && cacheBackend != "memory" && cacheBackend != "rueidis" {
+		log.Fatalf("Invalid CACHE_BACKEND, must be 'memory' (default), 'redis' or 'rueidis'")
+	}
+
+	compressionCodec := getenv("COMPRESSION_CODEC", "gzip")
+	if compressionCodec != "none" && compressionCodec != "gzip" && compressionCodec != "zstd" {
+		log.Fatalf("Invalid COMPRESSION_CODEC, must be 'none', 'gzip' (default) or 'zstd'")
+	}
+
+	compressionThreshold, err := strconv.Atoi(getenv("COMPRESSION_THRESHOLD_BYTES", "1024"))
+	if err != nil {
+		log.Fatalf("Error parsing COMPRESSION_THRESHOLD_BYTES: %v", err)
+	}
+
+	rueidisCacheSizeBytes, err := strconv.Atoi(getenv("RUEIDIS_CACHE_SIZE_BYTES", "134217728"))
+	if err != nil {
+		log.Fatalf("Error parsing RUEIDIS_CACHE_SIZE_BYTES: %v", err)
+	}
+
+	// redis and rueidis backends already require a live Redis connection, so
+	// Pub/Sub invalidation defaults on for them; a memory backend has no
+	// Redis of its own and must opt in explicitly.
+	pubSubInvalidation, err := strconv.ParseBool(getenv("PUBSUB_INVALIDATION", strconv.FormatBool(cacheBackend != "memory")))
+	if err != nil {
+		log.Fatalf("Error parsing PUBSUB_INVALIDATION: %v", err)
 	}
 
 	return &Config{
-		OriginURL: getenv("ORIGIN_URL", "https://httpbin.org"),
-		TTL:       time.Duration(ttlMinutes) * time.Minute,
-		Port:      getenv("PORT", "8080"),
-		RedisURL:  redisUrl,
-		CacheType: cacheType,
+		OriginURL:             getenv("ORIGIN_URL", "https://httpbin.org"),
+		TTL:                   time.Duration(ttlMinutes) * time.Minute,
+		StaleWhileRevalidate:  time.Duration(swrSeconds) * time.Second,
+		Port:                  getenv("PORT", "8080"),
+		RedisURL:              redisUrl,
+		CacheBackend:          cacheBackend,
+		RulesFile:             getenv("RULES_FILE", ""),
+		CompressionCodec:      compressionCodec,
+		CompressionThreshold:  compressionThreshold,
+		RueidisCacheSizeBytes: rueidisCacheSizeBytes,
+		PubSubInvalidation:    pubSubInvalidation,
 	}
 }
 
+// CacheRule is a per-path-regex override of the default caching behavior,
+// loaded from Config.RulesFile.
+type CacheRule struct {
+	PathPattern    string   `json:"path_pattern"`
+	Methods        []string `json:"methods,omitempty"`
+	TTL            string   `json:"ttl,omitempty"`
+	Bypass         bool     `json:"bypass,omitempty"`
+	IncludeParams  []string `json:"include_params,omitempty"`
+	ExcludeParams  []string `json:"exclude_params,omitempty"`
+	IncludeHeaders []string `json:"include_headers,omitempty"`
+
+	pattern *regexp.Regexp
+	ttl     time.Duration
+}
+
+// loadRules reads the per-route cache override table named by path. An
+// empty path disables routing overrides and yields no rules.
+func loadRules(path string) []CacheRule {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading RULES_FILE: %v", err)
+	}
+
+	var rules []CacheRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		log.Fatalf("Error parsing RULES_FILE: %v", err)
+	}
+
+	for i := range rules {
+		rules[i].pattern, err = regexp.Compile(rules[i].PathPattern)
+		if err != nil {
+			log.Fatalf("Invalid path_pattern %q in RULES_FILE: %v", rules[i].PathPattern, err)
+		}
+		if rules[i].TTL != "" {
+			rules[i].ttl, err = time.ParseDuration(rules[i].TTL)
+			if err != nil {
+				log.Fatalf("Invalid ttl %q in RULES_FILE: %v", rules[i].TTL, err)
+			}
+		}
+	}
+
+	return rules
+}
+
+// matchRule returns the first rule whose path_pattern matches path, or nil.
+func matchRule(rules []CacheRule, path string) *CacheRule {
+	for i := range rules {
+		if rules[i].pattern.MatchString(path) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// cacheableMethod reports whether r should be served from (and written to)
+// the cache. GET is always cacheable; a matched rule can additionally opt
+// other methods in via its methods list.
+func cacheableMethod(r *http.Request, rule *CacheRule) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+	if rule == nil {
+		return false
+	}
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, r.Method) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleCacheKey composes the base cache key for r, applying a matched rule's
+// query-param include/exclude list and header inclusions. Without a rule
+// (or an empty one), the full request URL is used as before. A rule can opt
+// non-GET methods into caching (cacheableMethod); their responses are kept
+// under a method-prefixed key so a cached HEAD and a cached GET of the same
+// URL never collide.
+func ruleCacheKey(r *http.Request, rule *CacheRule) string {
+	key := ruleCacheURLKey(r, rule)
+	if r.Method != http.MethodGet {
+		key = r.Method + " " + key
+	}
+	return key
+}
+
+// ruleCacheURLKey computes the URL (plus header-inclusion) portion of the
+// cache key, before the method prefix is applied.
+func ruleCacheURLKey(r *http.Request, rule *CacheRule) string {
+	if rule == nil || (len(rule.IncludeParams) == 0 && len(rule.ExcludeParams) == 0 && len(rule.IncludeHeaders) == 0) {
+		return r.URL.String()
+	}
+
+	u := *r.URL
+	q := u.Query()
+	if len(rule.IncludeParams) > 0 {
+		allowed := make(map[string]bool, len(rule.IncludeParams))
+		for _, p := range rule.IncludeParams {
+			allowed[p] = true
+		}
+		for key := range q {
+			if !allowed[key] {
+				q.Del(key)
+			}
+		}
+	}
+	for _, p := range rule.ExcludeParams {
+		q.Del(p)
+	}
+	u.RawQuery = q.Encode()
+
+	key := u.String()
+	if len(rule.IncludeHeaders) > 0 {
+		parts := make([]string, len(rule.IncludeHeaders))
+		for i, h := range rule.IncludeHeaders {
+			parts[i] = h + "=" + r.Header.Get(h)
+		}
+		key += "#" + strings.Join(parts, "&")
+	}
+	return key
+}
+
+// stripInternalHeaders removes Gleam's own cache-signaling headers so they
+// never reach the client or end up persisted in a cache entry.
+func stripInternalHeaders(header http.Header) {
+	header.Del("X-Gleam-Cache")
+	header.Del("X-Gleam-Cache-Ttl")
+}
+
 func getenv(key, fallback string) string {
 	value := os.Getenv(key)
 	if len(value) == 0 {
@@ -177,6 +658,277 @@ func getenv(key, fallback string) string {
 	return value
 }
 
+// cacheableStatusCodes are the response statuses eligible for caching per
+// RFC 7231 §6.1, trimmed to what a reverse proxy typically sees.
+var cacheableStatusCodes = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+}
+
+// cacheControlDirectives holds the RFC 7234 directives parsed from an
+// origin response's Cache-Control header.
+type cacheControlDirectives struct {
+	noStore                 bool
+	private                 bool
+	mustRevalidate          bool
+	hasMaxAge               bool
+	maxAge                  time.Duration
+	hasSMaxAge              bool
+	sMaxAge                 time.Duration
+	hasStaleWhileRevalidate bool
+	staleWhileRevalidate    time.Duration
+}
+
+// parseCacheControl parses the Cache-Control header of an origin response.
+// Unknown directives are ignored.
+func parseCacheControl(header http.Header) cacheControlDirectives {
+	var cc cacheControlDirectives
+
+	for _, part := range strings.Split(header.Get("Cache-Control"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name := part
+		value := ""
+		if idx := strings.Index(part, "="); idx != -1 {
+			name = strings.TrimSpace(part[:idx])
+			value = strings.Trim(strings.TrimSpace(part[idx+1:]), `"`)
+		}
+
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.noStore = true
+		case "private":
+			cc.private = true
+		case "must-revalidate", "proxy-revalidate":
+			cc.mustRevalidate = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.hasMaxAge = true
+				cc.maxAge = time.Duration(seconds) * time.Second
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.hasSMaxAge = true
+				cc.sMaxAge = time.Duration(seconds) * time.Second
+			}
+		case "stale-while-revalidate":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.hasStaleWhileRevalidate = true
+				cc.staleWhileRevalidate = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return cc
+}
+
+// cachePlan describes whether and how long a response should be cached,
+// derived from its status code and Cache-Control/Expires headers.
+type cachePlan struct {
+	cacheable            bool
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+}
+
+// planCaching decides whether a response is cacheable and, if so, for how
+// long. The origin can opt a response in or out regardless of its
+// Cache-Control via X-Gleam-Cache/X-Gleam-Cache-TTL; a matched rule's TTL
+// overrides the computed Cache-Control/Expires TTL. Absent any of those,
+// config.TTL/StaleWhileRevalidate are the fallback.
+func planCaching(status int, header http.Header, rule *CacheRule, config *Config) cachePlan {
+	if raw := header.Get("X-Gleam-Cache"); raw != "" {
+		if enabled, err := strconv.ParseBool(raw); err == nil {
+			if !enabled {
+				return cachePlan{}
+			}
+			ttl := config.TTL
+			if rule != nil && rule.ttl > 0 {
+				ttl = rule.ttl
+			}
+			if rawTTL := header.Get("X-Gleam-Cache-TTL"); rawTTL != "" {
+				if d, err := time.ParseDuration(rawTTL); err == nil {
+					ttl = d
+				}
+			}
+			return cachePlan{cacheable: true, ttl: ttl, staleWhileRevalidate: config.StaleWhileRevalidate}
+		}
+	}
+
+	if !cacheableStatusCodes[status] {
+		return cachePlan{}
+	}
+
+	cc := parseCacheControl(header)
+	ruleForcesTTL := rule != nil && rule.ttl > 0
+	if (cc.noStore || cc.private) && !ruleForcesTTL {
+		return cachePlan{}
+	}
+
+	ttl := config.TTL
+	switch {
+	case ruleForcesTTL:
+		ttl = rule.ttl
+	case cc.hasSMaxAge:
+		ttl = cc.sMaxAge
+	case cc.hasMaxAge:
+		ttl = cc.maxAge
+	default:
+		if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+			ttl = time.Until(expires)
+		}
+	}
+	if ttl <= 0 {
+		return cachePlan{}
+	}
+
+	swr := config.StaleWhileRevalidate
+	if cc.hasStaleWhileRevalidate {
+		swr = cc.staleWhileRevalidate
+	}
+	if cc.mustRevalidate {
+		swr = 0
+	}
+
+	return cachePlan{cacheable: true, ttl: ttl, staleWhileRevalidate: swr}
+}
+
+// varyIndex remembers, per base cache key, which request headers the
+// origin's Vary response header named, so that future lookups for the same
+// URL can be composed into the right vary-aware cache key before the
+// response has been fetched again.
+var varyIndex sync.Map // map[string][]string
+
+// varyCacheKey composes the cache key for r against baseKey, folding in any
+// request header values previously recorded as Vary dimensions for baseKey.
+func varyCacheKey(baseKey string, r *http.Request) string {
+	v, ok := varyIndex.Load(baseKey)
+	if !ok {
+		return baseKey
+	}
+	fields := v.([]string)
+	if len(fields) == 0 {
+		return baseKey
+	}
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field + "=" + r.Header.Get(field)
+	}
+	return baseKey + "#" + strings.Join(parts, "&")
+}
+
+// recordVary records the Vary dimensions for baseKey from an origin
+// response and returns the cache key that response should be stored under.
+func recordVary(baseKey string, header http.Header, r *http.Request) string {
+	varyHeader := header.Get("Vary")
+	if varyHeader == "" {
+		return baseKey
+	}
+
+	fields := strings.Split(varyHeader, ",")
+	for i, f := range fields {
+		fields[i] = http.CanonicalHeaderKey(strings.TrimSpace(f))
+	}
+	sort.Strings(fields)
+	varyIndex.Store(baseKey, fields)
+
+	return varyCacheKey(baseKey, r)
+}
+
+// invalidationChannel is the Redis Pub/Sub channel Gleam instances use to
+// tell each other about purges.
+const invalidationChannel = "gleam:invalidate"
+
+// invalidationMessage is the Pub/Sub envelope for cross-instance cache
+// invalidation, modeled on blocky's redisMessage: a type discriminator,
+// the key (or pattern) being invalidated, and the originating instance's
+// client id so receivers can filter out their own messages.
+type invalidationMessage struct {
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	ClientID string `json:"client_id"`
+}
+
+const invalidationTypePurge = "purge"
+
+// invalidator coordinates cache invalidation across multiple Gleam
+// instances sharing a cache (RedisCache/RueidisCache) or running
+// independently behind a load balancer (SimpleCache), via Redis Pub/Sub.
+type invalidator struct {
+	client   *redis.Client
+	cache    Cache
+	clientID string
+}
+
+// newInvalidator creates an invalidator that publishes to and subscribes
+// from redisURL; it does not dial until first used.
+func newInvalidator(redisURL string, cache Cache) *invalidator {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("Failed to parse Redis URL: %v", err)
+	}
+
+	return &invalidator{
+		client:   redis.NewClient(opt),
+		cache:    cache,
+		clientID: uuid.NewString(),
+	}
+}
+
+// listen subscribes to invalidationChannel until ctx is done, purging this
+// instance's cache for every message that didn't originate here.
+func (inv *invalidator) listen(ctx context.Context) {
+	sub := inv.client.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var m invalidationMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+			continue
+		}
+		if m.ClientID == inv.clientID {
+			continue // self-ignore
+		}
+		inv.cache.Purge(m.Key)
+	}
+}
+
+// purge removes pattern from this instance's cache and publishes the
+// invalidation so every other instance does the same.
+func (inv *invalidator) purge(pattern string) {
+	inv.cache.Purge(pattern)
+
+	payload, err := json.Marshal(invalidationMessage{
+		Type:     invalidationTypePurge,
+		Key:      pattern,
+		ClientID: inv.clientID,
+	})
+	if err != nil {
+		return
+	}
+	inv.client.Publish(ctx, invalidationChannel, payload)
+}
+
+// isMutatingMethod reports whether method is one that plausibly changes
+// the resource a cached GET response describes.
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodDelete, http.MethodPut, http.MethodPost:
+		return true
+	default:
+		return false
+	}
+}
+
 func main() {
 	config := loadConfig()
 
@@ -184,178 +936,304 @@ func main() {
 
 	var cache Cache
 
-	if config.CacheType == "redis" {
-		cache = NewRedisCache(config.RedisURL)
-	} else {
+	switch config.CacheBackend {
+	case "redis":
+		cache = NewRedisCache(config.RedisURL, config)
+	case "rueidis":
+		cache = NewRueidisCache(config.RedisURL, config)
+	default:
 		cache = NewSimpleCache()
 	}
 
 	origin, _ := url.Parse(config.OriginURL) // URL of the backend server
 	proxy := httputil.NewSingleHostReverseProxy(origin)
-	ttl := config.TTL // Time to live for cache entries
+	rules := loadRules(config.RulesFile)
+
+	var inv *invalidator
+	if config.PubSubInvalidation {
+		inv = newInvalidator(config.RedisURL, cache)
+		go inv.listen(ctx)
+	} else {
+		log.Printf("PubSub cache invalidation disabled (CACHE_BACKEND=%s); purges apply only to this instance", config.CacheBackend)
+	}
+
+	// purge removes pattern from this instance's cache and, if Pub/Sub
+	// invalidation is enabled, announces the purge so every other instance
+	// does the same.
+	purge := func(pattern string) {
+		if inv != nil {
+			inv.purge(pattern)
+			return
+		}
+		cache.Purge(pattern)
+	}
+
+	var fetchGroup singleflight.Group
+
+	http.HandleFunc("/_gleam/purge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pattern := r.URL.Query().Get("pattern")
+		if pattern == "" {
+			pattern = r.URL.Query().Get("key")
+		}
+		if pattern == "" {
+			http.Error(w, "pattern (or key) query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		purge(pattern)
+		w.WriteHeader(http.StatusNoContent)
+	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Received request: %s %s", r.Method, r.URL.Path)
 
-		if r.Method == "GET" {
-			cacheKey := r.URL.String()
-			if cachedItem, found := cache.Get(cacheKey); found {
-				for key, values := range cachedItem.header {
-					for _, value := range values {
-						w.Header().Add(key, value)
-					}
-				}
-				w.Write(cachedItem.content)
-				return
+		rule := matchRule(rules, r.URL.Path)
+
+		if (rule != nil && rule.Bypass) || !cacheableMethod(r, rule) {
+			proxy.ServeHTTP(w, r)
+			if isMutatingMethod(r.Method) {
+				go purge(r.URL.String() + "*")
 			}
+			return
+		}
 
-			crw := &CacheResponseWriter{ResponseWriter: w, buf: new(bytes.Buffer)}
-			proxy.ServeHTTP(crw, r)
+		baseKey := ruleCacheKey(r, rule)
+		cacheKey := varyCacheKey(baseKey, r)
 
-			cache.Set(cacheKey, crw.buf.Bytes(), crw.Header(), ttl)
-		} else {
-			proxy.ServeHTTP(w, r)
+		if item, found := cache.Get(cacheKey); found {
+			if item.fresh() {
+				w.Header().Set("X-Cache", "HIT")
+			} else {
+				w.Header().Set("X-Cache", "STALE")
+				go refreshFromOrigin(&fetchGroup, cache, proxy, r, baseKey, cacheKey, rule, config)
+			}
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(item.storedAt).Seconds())))
+			writeCacheItem(w, item)
+			return
+		}
+
+		rec := fetchFromOrigin(&fetchGroup, proxy, r, cacheKey)
+		plan := planCaching(rec.status, rec.header, rule, config)
+		stripInternalHeaders(rec.header)
+
+		for key, values := range rec.header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
 		}
+		w.Header().Set("X-Cache", "MISS")
+		w.Header().Set("Age", "0")
+		w.WriteHeader(rec.status)
+		w.Write(rec.buf.Bytes())
+
+		cacheResponse(cache, baseKey, rec, r, plan)
 	})
 
 	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
 }
 
-func encodeCacheItem(item CacheItem) ([]byte, error) {
-	// Initialize a buffer to write the data into
-	var buf bytes.Buffer
+// fetchFromOrigin fetches r from proxy, coalescing concurrent fetches for
+// the same groupKey behind a single origin request via singleflight.
+// Callers pass the vary-aware cache key (not the bare baseKey) so that
+// requests already known to differ by a recorded Vary dimension aren't
+// merged into one fetch and handed the wrong variant. This only helps once
+// a Vary has been recorded for baseKey: the very first concurrent misses
+// for a URL whose Vary isn't known yet can still coalesce across what turn
+// out to be different variants.
+//
+// Every waiter gets its own recordingWriter, with the header cloned off the
+// shared one, so that each caller can freely mutate its copy (e.g. via
+// stripInternalHeaders) without racing the others.
+func fetchFromOrigin(group *singleflight.Group, proxy *httputil.ReverseProxy, r *http.Request, groupKey string) *recordingWriter {
+	v, _, _ := group.Do(groupKey, func() (interface{}, error) {
+		rec := newRecordingWriter()
+		proxy.ServeHTTP(rec, r)
+		return rec, nil
+	})
+	shared := v.(*recordingWriter)
+	return &recordingWriter{header: shared.header.Clone(), buf: shared.buf, status: shared.status}
+}
 
-	// Write content length and content
-	contentLen := uint32(len(item.content))
-	if err := binary.Write(&buf, binary.LittleEndian, contentLen); err != nil {
-		return nil, err
-	}
-	if _, err := buf.Write(item.content); err != nil {
-		return nil, err
-	}
+// refreshFromOrigin re-fetches a stale entry in the background and, if the
+// response is still cacheable, replaces the cache entry in place. groupKey
+// is the vary-aware key to coalesce the origin fetch on; baseKey is used to
+// index (and re-derive, if Vary changed) the stored entry.
+func refreshFromOrigin(group *singleflight.Group, cache Cache, proxy *httputil.ReverseProxy, r *http.Request, baseKey, groupKey string, rule *CacheRule, config *Config) {
+	rec := fetchFromOrigin(group, proxy, r.Clone(context.Background()), groupKey)
+	plan := planCaching(rec.status, rec.header, rule, config)
+	stripInternalHeaders(rec.header)
+	cacheResponse(cache, baseKey, rec, r, plan)
+}
 
-	// Write the headers
-	headerLen := uint32(len(item.header))
-	if err := binary.Write(&buf, binary.LittleEndian, headerLen); err != nil {
-		return nil, err
+// cacheResponse stores rec in cache under the vary-aware key for baseKey, if
+// plan (computed by planCaching against rec's original, pre-strip header)
+// says it's cacheable.
+func cacheResponse(cache Cache, baseKey string, rec *recordingWriter, r *http.Request, plan cachePlan) {
+	if !plan.cacheable {
+		return
 	}
-	for key, values := range item.header {
-		// Write the header key
-		keyLen := uint32(len(key))
-		if err := binary.Write(&buf, binary.LittleEndian, keyLen); err != nil {
-			return nil, err
-		}
-		if _, err := buf.Write([]byte(key)); err != nil {
-			return nil, err
-		}
 
-		// Write the number of values for this header key
-		valuesLen := uint32(len(values))
-		if err := binary.Write(&buf, binary.LittleEndian, valuesLen); err != nil {
-			return nil, err
-		}
+	cacheKey := recordVary(baseKey, rec.header, r)
+	cache.Set(cacheKey, rec.buf.Bytes(), rec.header, rec.status, plan.ttl, plan.staleWhileRevalidate)
+}
+
+// writeCacheItem replays a cached item onto a live ResponseWriter.
+func writeCacheItem(w http.ResponseWriter, item *CacheItem) {
+	for key, values := range item.header {
 		for _, value := range values {
-			// Write the value
-			valueLen := uint32(len(value))
-			if err := binary.Write(&buf, binary.LittleEndian, valueLen); err != nil {
-				return nil, err
-			}
-			if _, err := buf.Write([]byte(value)); err != nil {
-				return nil, err
-			}
+			w.Header().Add(key, value)
 		}
 	}
+	w.WriteHeader(item.status)
+	w.Write(item.content)
+}
 
-	// Write expiration time
-	expirationBytes, err := item.expiration.MarshalBinary()
-	if err != nil {
-		return nil, err
+// cacheItemMagic and cacheItemVersion are the leading two bytes of every
+// encoded cache entry, letting decodeCacheItem reject garbage and evolve
+// the wire struct without breaking entries written by an older version.
+const (
+	cacheItemMagic   byte = 0x9c
+	cacheItemVersion byte = 1
+)
+
+// compressionCodec is the third byte of an encoded cache entry, naming the
+// compression (if any) applied to the MessagePack payload that follows.
+type compressionCodec byte
+
+const (
+	compressionNone compressionCodec = iota
+	compressionGzip
+	compressionZstd
+)
+
+// cacheItemWire is the MessagePack-tagged shape of a CacheItem on the wire.
+// Field numbers (the msgpack tags) are fixed so that adding a field later
+// doesn't break decoding of entries written before the addition.
+type cacheItemWire struct {
+	Content    []byte              `msgpack:"0"`
+	Header     map[string][]string `msgpack:"1"`
+	Status     int                 `msgpack:"2"`
+	StoredAt   int64               `msgpack:"3"` // UnixNano
+	Expiration int64               `msgpack:"4"` // UnixNano
+	StaleUntil int64               `msgpack:"5"` // UnixNano
+}
+
+// encodeCacheItem serializes item as [magic][version][codec]<payload>. The
+// payload is MessagePack, gzip- or zstd-compressed once it reaches
+// config.CompressionThreshold bytes; config may be nil, in which case no
+// compression is applied.
+func encodeCacheItem(item CacheItem, config *Config) ([]byte, error) {
+	wire := cacheItemWire{
+		Content:    item.content,
+		Header:     map[string][]string(item.header),
+		Status:     item.status,
+		StoredAt:   item.storedAt.UnixNano(),
+		Expiration: item.expiration.UnixNano(),
+		StaleUntil: item.staleUntil.UnixNano(),
 	}
-	expirationLen := uint32(len(expirationBytes))
-	if err := binary.Write(&buf, binary.LittleEndian, expirationLen); err != nil {
+
+	payload, err := msgpack.Marshal(&wire)
+	if err != nil {
 		return nil, err
 	}
-	if _, err := buf.Write(expirationBytes); err != nil {
-		return nil, err
+
+	codec := compressionNone
+	if config != nil && len(payload) >= config.CompressionThreshold {
+		compressed, c, err := compressPayload(payload, config.CompressionCodec)
+		if err != nil {
+			return nil, err
+		}
+		payload, codec = compressed, c
 	}
 
-	// Base64 encode the resulting byte slice
-	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return []byte(encoded), nil
+	out := make([]byte, 0, len(payload)+3)
+	out = append(out, cacheItemMagic, cacheItemVersion, byte(codec))
+	out = append(out, payload...)
+	return out, nil
 }
 
+// decodeCacheItem reverses encodeCacheItem, self-describing the
+// compression codec from the entry's header byte regardless of the
+// current process's configuration.
 func decodeCacheItem(data []byte) (*CacheItem, error) {
-	// Decode the base64 input
-	decoded, err := base64.StdEncoding.DecodeString(string(data))
-	if err != nil {
-		return nil, err
+	if len(data) < 3 || data[0] != cacheItemMagic {
+		return nil, fmt.Errorf("gleam: unrecognized cache entry format")
 	}
-
-	buf := bytes.NewReader(decoded)
-	item := &CacheItem{}
-
-	// Read content length and content
-	var contentLen uint32
-	if err := binary.Read(buf, binary.LittleEndian, &contentLen); err != nil {
-		return nil, err
+	if data[1] != cacheItemVersion {
+		return nil, fmt.Errorf("gleam: unsupported cache entry version %d", data[1])
 	}
-	item.content = make([]byte, contentLen)
-	if _, err := buf.Read(item.content); err != nil {
+
+	payload, err := decompressPayload(data[3:], compressionCodec(data[2]))
+	if err != nil {
 		return nil, err
 	}
 
-	// Read the headers
-	var headerLen uint32
-	if err := binary.Read(buf, binary.LittleEndian, &headerLen); err != nil {
+	var wire cacheItemWire
+	if err := msgpack.Unmarshal(payload, &wire); err != nil {
 		return nil, err
 	}
-	item.header = make(http.Header, headerLen)
-	for i := uint32(0); i < headerLen; i++ {
-		// Read the header key
-		var keyLen uint32
-		if err := binary.Read(buf, binary.LittleEndian, &keyLen); err != nil {
-			return nil, err
+
+	return &CacheItem{
+		content:    wire.Content,
+		header:     http.Header(wire.Header),
+		status:     wire.Status,
+		storedAt:   time.Unix(0, wire.StoredAt),
+		expiration: time.Unix(0, wire.Expiration),
+		staleUntil: time.Unix(0, wire.StaleUntil),
+	}, nil
+}
+
+// compressPayload compresses payload with the named codec ("gzip" or
+// "zstd"; anything else, including "none", leaves it uncompressed).
+func compressPayload(payload []byte, codecName string) ([]byte, compressionCodec, error) {
+	switch codecName {
+	case "zstd":
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, compressionNone, err
 		}
-		key := make([]byte, keyLen)
-		if _, err := buf.Read(key); err != nil {
-			return nil, err
+		defer enc.Close()
+		return enc.EncodeAll(payload, nil), compressionZstd, nil
+	case "gzip":
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(payload); err != nil {
+			return nil, compressionNone, err
 		}
+		if err := gw.Close(); err != nil {
+			return nil, compressionNone, err
+		}
+		return buf.Bytes(), compressionGzip, nil
+	default:
+		return payload, compressionNone, nil
+	}
+}
 
-		// Read the number of values for this header key
-		var valuesLen uint32
-		if err := binary.Read(buf, binary.LittleEndian, &valuesLen); err != nil {
+// decompressPayload reverses compressPayload for the codec named in an
+// entry's header byte.
+func decompressPayload(payload []byte, codec compressionCodec) ([]byte, error) {
+	switch codec {
+	case compressionNone:
+		return payload, nil
+	case compressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
 			return nil, err
 		}
-		values := make([]string, valuesLen)
-		for j := uint32(0); j < valuesLen; j++ {
-			// Read each value
-			var valueLen uint32
-			if err := binary.Read(buf, binary.LittleEndian, &valueLen); err != nil {
-				return nil, err
-			}
-			value := make([]byte, valueLen)
-			if _, err := buf.Read(value); err != nil {
-				return nil, err
-			}
-			values[j] = string(value)
+		defer gr.Close()
+		return io.ReadAll(gr)
+	case compressionZstd:
+		dec, err := zstd.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
 		}
-
-		// Store the key-value pair in the header map
-		item.header[string(key)] = values
-	}
-
-	// Read expiration time
-	var expirationLen uint32
-	if err := binary.Read(buf, binary.LittleEndian, &expirationLen); err != nil {
-		return nil, err
+		defer dec.Close()
+		return io.ReadAll(dec)
+	default:
+		return nil, fmt.Errorf("gleam: unknown compression codec %d", codec)
 	}
-	expirationBytes := make([]byte, expirationLen)
-	if _, err := buf.Read(expirationBytes); err != nil {
-		return nil, err
-	}
-	if err := item.expiration.UnmarshalBinary(expirationBytes); err != nil {
-		return nil, err
-	}
-
-	return item, nil
 }
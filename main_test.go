@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -13,7 +15,7 @@ func TestSimpleCache(t *testing.T) {
 	cache := NewSimpleCache()
 
 	// Test Set and Get
-	cache.Set("key1", []byte("value1"), http.Header{}, 1*time.Minute)
+	cache.Set("key1", []byte("value1"), http.Header{}, http.StatusOK, 1*time.Minute, 0)
 	item, found := cache.Get("key1")
 	if !found {
 		t.Error("Expected to find key1 in cache")
@@ -21,14 +23,139 @@ func TestSimpleCache(t *testing.T) {
 	if string(item.content) != "value1" {
 		t.Errorf("Expected value1, got %s", string(item.content))
 	}
+	if !item.fresh() {
+		t.Error("Expected key1 to be fresh")
+	}
 
 	// Test expiration
-	cache.Set("key2", []byte("value2"), http.Header{}, 1*time.Nanosecond)
+	cache.Set("key2", []byte("value2"), http.Header{}, http.StatusOK, 1*time.Nanosecond, 0)
 	time.Sleep(1 * time.Millisecond)
 	_, found = cache.Get("key2")
 	if found {
 		t.Error("Expected key2 to be expired")
 	}
+
+	// Test stale-while-revalidate window
+	cache.Set("key3", []byte("value3"), http.Header{}, http.StatusOK, 1*time.Nanosecond, 1*time.Minute)
+	time.Sleep(1 * time.Millisecond)
+	item, found = cache.Get("key3")
+	if !found {
+		t.Error("Expected key3 to still be servable within its stale window")
+	}
+	if item.fresh() {
+		t.Error("Expected key3 to be stale")
+	}
+}
+
+func TestSimpleCacheSweepEvictsExpired(t *testing.T) {
+	cache := NewSimpleCacheWithContext(context.Background(), 5*time.Millisecond, nil)
+
+	cache.Set("key1", []byte("value1"), http.Header{}, http.StatusOK, 1*time.Nanosecond, 0)
+	cache.sweep(context.Background())
+
+	cache.mu.Lock()
+	_, found := cache.store["key1"]
+	cache.mu.Unlock()
+	if found {
+		t.Error("Expected key1 to be evicted by sweep")
+	}
+}
+
+func TestSimpleCacheSweepPreExpirationRefresh(t *testing.T) {
+	refreshed := false
+	preExpirationFn := func(ctx context.Context, key string) ([]byte, http.Header, time.Duration) {
+		refreshed = true
+		return []byte("refreshed"), http.Header{}, 1 * time.Minute
+	}
+	cache := NewSimpleCacheWithContext(context.Background(), 5*time.Millisecond, preExpirationFn)
+
+	cache.Set("key1", []byte("value1"), http.Header{}, http.StatusOK, 1*time.Nanosecond, 0)
+	cache.sweep(context.Background())
+
+	if !refreshed {
+		t.Error("Expected preExpirationFn to be called")
+	}
+	item, found := cache.Get("key1")
+	if !found {
+		t.Fatal("Expected key1 to have been refreshed instead of evicted")
+	}
+	if string(item.content) != "refreshed" {
+		t.Errorf("Expected refreshed content, got %s", string(item.content))
+	}
+}
+
+func TestEncodeDecodeCacheItemRoundTrip(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	item := CacheItem{
+		content:    []byte("hello, world"),
+		header:     header,
+		status:     http.StatusOK,
+		storedAt:   time.Now(),
+		expiration: time.Now().Add(1 * time.Minute),
+		staleUntil: time.Now().Add(2 * time.Minute),
+	}
+
+	for _, codec := range []string{"none", "gzip", "zstd"} {
+		config := &Config{CompressionCodec: codec, CompressionThreshold: 0}
+
+		encoded, err := encodeCacheItem(item, config)
+		if err != nil {
+			t.Fatalf("encodeCacheItem(%s) failed: %v", codec, err)
+		}
+
+		decoded, err := decodeCacheItem(encoded)
+		if err != nil {
+			t.Fatalf("decodeCacheItem(%s) failed: %v", codec, err)
+		}
+		if string(decoded.content) != string(item.content) {
+			t.Errorf("%s: expected content %q, got %q", codec, item.content, decoded.content)
+		}
+		if decoded.status != item.status {
+			t.Errorf("%s: expected status %d, got %d", codec, item.status, decoded.status)
+		}
+		if decoded.header.Get("Content-Type") != "text/plain" {
+			t.Errorf("%s: expected Content-Type header to survive round trip, got %q", codec, decoded.header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestDecodeCacheItemRejectsUnknownFormat(t *testing.T) {
+	if _, err := decodeCacheItem([]byte("not a gleam cache entry")); err == nil {
+		t.Error("Expected decodeCacheItem to reject data without the gleam magic byte")
+	}
+}
+
+func TestSimpleCachePurge(t *testing.T) {
+	cache := NewSimpleCache()
+	cache.Set("/widgets?color=red", []byte("red"), http.Header{}, http.StatusOK, 1*time.Minute, 0)
+	cache.Set("/widgets?color=blue", []byte("blue"), http.Header{}, http.StatusOK, 1*time.Minute, 0)
+	cache.Set("/gadgets", []byte("gadget"), http.Header{}, http.StatusOK, 1*time.Minute, 0)
+
+	cache.Purge("/widgets?color=*")
+
+	if _, found := cache.Get("/widgets?color=red"); found {
+		t.Error("Expected /widgets?color=red to be purged")
+	}
+	if _, found := cache.Get("/widgets?color=blue"); found {
+		t.Error("Expected /widgets?color=blue to be purged")
+	}
+	if _, found := cache.Get("/gadgets"); !found {
+		t.Error("Expected /gadgets to survive an unrelated purge pattern")
+	}
+}
+
+func TestIsMutatingMethod(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		if !isMutatingMethod(method) {
+			t.Errorf("Expected %s to be treated as mutating", method)
+		}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if isMutatingMethod(method) {
+			t.Errorf("Expected %s not to be treated as mutating", method)
+		}
+	}
 }
 
 func TestCacheResponseWriter(t *testing.T) {
@@ -47,17 +174,73 @@ func TestCacheResponseWriter(t *testing.T) {
 	}
 }
 
+func TestMatchRuleAndCacheableMethod(t *testing.T) {
+	rules := []CacheRule{
+		{PathPattern: "^/api/"},
+	}
+	rules[0].pattern = mustCompile(t, rules[0].PathPattern)
+	rules[0].Methods = []string{"HEAD"}
+
+	if matchRule(rules, "/other") != nil {
+		t.Error("Expected no rule to match /other")
+	}
+	rule := matchRule(rules, "/api/widgets")
+	if rule == nil {
+		t.Fatal("Expected a rule to match /api/widgets")
+	}
+
+	headReq := httptest.NewRequest(http.MethodHead, "/api/widgets", nil)
+	if !cacheableMethod(headReq, rule) {
+		t.Error("Expected HEAD to be cacheable under the matched rule")
+	}
+
+	postReq := httptest.NewRequest(http.MethodPost, "/api/widgets", nil)
+	if cacheableMethod(postReq, rule) {
+		t.Error("Expected POST to remain uncacheable")
+	}
+}
+
+func TestPlanCachingGleamHeaderOverride(t *testing.T) {
+	config := &Config{TTL: 1 * time.Minute}
+
+	header := http.Header{}
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Gleam-Cache", "true")
+	header.Set("X-Gleam-Cache-TTL", "30s")
+
+	plan := planCaching(http.StatusOK, header, nil, config)
+	if !plan.cacheable {
+		t.Error("Expected X-Gleam-Cache: true to force caching despite no-store")
+	}
+	if plan.ttl != 30*time.Second {
+		t.Errorf("Expected ttl 30s from X-Gleam-Cache-TTL, got %v", plan.ttl)
+	}
+
+	header.Set("X-Gleam-Cache", "false")
+	plan = planCaching(http.StatusOK, header, nil, config)
+	if plan.cacheable {
+		t.Error("Expected X-Gleam-Cache: false to forbid caching")
+	}
+}
+
+func mustCompile(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern %q: %v", pattern, err)
+	}
+	return re
+}
+
 func TestLoadConfig(t *testing.T) {
 	// Save current env vars
 	oldOriginURL := os.Getenv("ORIGIN_URL")
 	oldTTLMinutes := os.Getenv("TTL_MINUTES")
-	oldCacheSize := os.Getenv("CACHE_SIZE")
 	oldPort := os.Getenv("PORT")
 
 	// Set test env vars
 	os.Setenv("ORIGIN_URL", "https://example.com")
 	os.Setenv("TTL_MINUTES", "10")
-	os.Setenv("CACHE_SIZE", "200")
 	os.Setenv("PORT", "9090")
 
 	config := loadConfig()
@@ -68,9 +251,6 @@ func TestLoadConfig(t *testing.T) {
 	if config.TTL != 10*time.Minute {
 		t.Errorf("Expected TTL to be 10 minutes, got %v", config.TTL)
 	}
-	if config.CacheSize != 200 {
-		t.Errorf("Expected CacheSize to be 200, got %d", config.CacheSize)
-	}
 	if config.Port != "9090" {
 		t.Errorf("Expected Port to be 9090, got %s", config.Port)
 	}
@@ -78,6 +258,5 @@ func TestLoadConfig(t *testing.T) {
 	// Restore original env vars
 	os.Setenv("ORIGIN_URL", oldOriginURL)
 	os.Setenv("TTL_MINUTES", oldTTLMinutes)
-	os.Setenv("CACHE_SIZE", oldCacheSize)
 	os.Setenv("PORT", oldPort)
 }